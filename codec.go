@@ -0,0 +1,102 @@
+package mgdb
+
+import (
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Codec abstracts the serialization format a Driver uses to persist and
+// load records, so a database can be backed by JSON, BSON, or MessagePack
+// without changing the collection/resource API. Extension is consulted
+// both when naming new files and when falling back to find existing ones,
+// so a database should stick to one Codec for its whole lifetime.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	Extension() string
+}
+
+// JSONCodec is the default Codec, matching the driver's original on-disk
+// format: indented JSON with a trailing newline.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	bytes, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes, '\n'), nil
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (JSONCodec) Extension() string { return "json" }
+
+// BSONCodec stores records as BSON, keeping time.Time, []byte, and
+// ObjectID values in their native binary form instead of stringifying
+// them the way JSON does.
+type BSONCodec struct{}
+
+func (BSONCodec) Marshal(v any) ([]byte, error) {
+	return bson.Marshal(v)
+}
+
+func (BSONCodec) Unmarshal(data []byte, v any) error {
+	return bson.Unmarshal(data, v)
+}
+
+func (BSONCodec) Extension() string { return "bson" }
+
+// MsgpackCodec stores records as MessagePack, a compact binary format.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (MsgpackCodec) Extension() string { return "msgpack" }
+
+// normalizeDoc converts a value decoded by Codec.Unmarshal into an `any`
+// into the same map[string]any / []any / scalar shape regardless of
+// which Codec produced it, so getPath and re-encoding to JSON behave
+// identically across codecs. This matters for BSONCodec in particular:
+// bson.Unmarshal into an `any` yields bson.D/bson.A for documents and
+// arrays rather than map[string]any/[]any, which getPath can't walk and
+// which encoding/json would marshal as an array of {Key,Value} pairs
+// instead of an object.
+func normalizeDoc(v any) any {
+	switch t := v.(type) {
+	case bson.D:
+		m := make(map[string]any, len(t))
+		for _, e := range t {
+			m[e.Key] = normalizeDoc(e.Value)
+		}
+		return m
+	case bson.A:
+		arr := make([]any, len(t))
+		for i, e := range t {
+			arr[i] = normalizeDoc(e)
+		}
+		return arr
+	case map[string]any:
+		for k, val := range t {
+			t[k] = normalizeDoc(val)
+		}
+		return t
+	case []any:
+		for i, val := range t {
+			t[i] = normalizeDoc(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,40 @@
+package mgdb
+
+import (
+	"testing"
+	"time"
+)
+
+type bsonTestRecord struct {
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// TestReadRoundTripsBSON guards against Read routing the caller's pointer
+// through an extra layer of indirection: unmarshaling into &v where v
+// already holds the caller's *T can decode BSON into a generic value
+// instead of the caller's struct, silently losing the richer types
+// (time.Time, []byte, ObjectID) BSONCodec exists to preserve.
+func TestReadRoundTripsBSON(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, &Options{Codec: BSONCodec{}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	want := bsonTestRecord{Name: "alice", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := driver.Write("users", "alice", want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var got bsonTestRecord
+	if err := driver.Read("users", "alice", &got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if got.Name != want.Name || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("round-trip mismatch: got %#v, want %#v", got, want)
+	}
+}
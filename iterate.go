@@ -0,0 +1,190 @@
+package mgdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Iterate calls fn once for every non-expired resource in collection, in
+// directory order, decoding each file to a json.RawMessage regardless of
+// the Driver's Codec. Unlike the old ReadAll, it never holds more than
+// one decoded record in memory at a time, so it stays cheap on large
+// collections. Iteration stops at the first error fn returns, and that
+// error is returned from Iterate.
+func (d *Driver) Iterate(collection string, fn func(resource string, raw json.RawMessage) error) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	ext := "." + d.codec.Extension()
+	dir := filepath.Join(d.directory, collection)
+
+	if _, err := stat(dir, ext); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isResourceFile(file.Name(), ext) {
+			continue
+		}
+
+		resource := strings.TrimSuffix(file.Name(), ext)
+
+		if expired, err := d.expired(collection, resource); err == nil && expired {
+			continue
+		}
+
+		lock := d.resourceLock(collection, resource)
+		lock.RLock()
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		var doc any
+		if err := d.codec.Unmarshal(bytes, &doc); err != nil {
+			return err
+		}
+
+		raw, err := json.Marshal(normalizeDoc(doc))
+		if err != nil {
+			return err
+		}
+
+		if err := fn(resource, raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadAllInto decodes every non-expired resource in collection straight
+// into sliceOutPtr, which must be a pointer to a slice; the slice's
+// element type is used as the decode target for each file, so codecs
+// that preserve richer types (time.Time, []byte, ObjectIDs under BSON)
+// round-trip without going through an intermediate json.RawMessage.
+func (d *Driver) ReadAllInto(collection string, sliceOutPtr any) error {
+	ptr := reflect.ValueOf(sliceOutPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("mgdb: ReadAllInto requires a pointer to a slice, got %T", sliceOutPtr)
+	}
+
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to read")
+	}
+
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	sliceValue := ptr.Elem()
+	elemType := sliceValue.Type().Elem()
+	result := reflect.MakeSlice(sliceValue.Type(), 0, 0)
+
+	ext := "." + d.codec.Extension()
+	dir := filepath.Join(d.directory, collection)
+
+	if _, err := stat(dir, ext); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isResourceFile(file.Name(), ext) {
+			continue
+		}
+
+		resource := strings.TrimSuffix(file.Name(), ext)
+
+		if expired, err := d.expired(collection, resource); err == nil && expired {
+			continue
+		}
+
+		lock := d.resourceLock(collection, resource)
+		lock.RLock()
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		elem := reflect.New(elemType)
+		if err := d.codec.Unmarshal(bytes, elem.Interface()); err != nil {
+			return err
+		}
+
+		result = reflect.Append(result, elem.Elem())
+	}
+
+	sliceValue.Set(result)
+	return nil
+}
+
+// errStopReadPage unwinds ReadPage's Iterate callback once it has
+// collected enough records, so Iterate doesn't keep decoding the rest of
+// the collection after the page is full.
+var errStopReadPage = errors.New("mgdb: read page complete")
+
+// ReadPage returns up to limit records from collection, skipping the
+// first offset matches. When sortBy is empty, records are returned in
+// directory order and decoding stops as soon as the page is full, so it
+// never decodes the whole collection just to return a handful of
+// records. A non-empty sortBy orders by the value at that path instead,
+// which requires comparing every record's sort key first, so that case
+// still decodes the whole collection before paging.
+func (d *Driver) ReadPage(collection string, offset, limit int, sortBy string) ([]json.RawMessage, error) {
+	if sortBy != "" {
+		filter := Filter{skip: offset, limit: limit, sortBy: sortBy}
+		return d.Find(collection, filter)
+	}
+
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to read")
+	}
+
+	var page []json.RawMessage
+	seen := 0
+
+	err := d.Iterate(collection, func(resource string, raw json.RawMessage) error {
+		if seen < offset {
+			seen++
+			return nil
+		}
+		seen++
+
+		page = append(page, raw)
+		if limit > 0 && len(page) >= limit {
+			// Iterate decodes a file before invoking this callback, so
+			// stopping here (rather than waiting for the next call to
+			// notice the page is full) avoids decoding one record past
+			// offset+limit that would never make it into the page.
+			return errStopReadPage
+		}
+		return nil
+	})
+	if err != nil && err != errStopReadPage {
+		return nil, err
+	}
+
+	return page, nil
+}
@@ -0,0 +1,189 @@
+package mgdb
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type iterateTestRecord struct {
+	Name string `json:"name"`
+	Seq  int    `json:"seq"`
+}
+
+// countingCodec wraps JSONCodec and counts Unmarshal calls, so tests can
+// assert how many records a bounded read actually decoded rather than
+// just checking the records it returned.
+type countingCodec struct {
+	JSONCodec
+	unmarshals *int
+}
+
+func (c countingCodec) Unmarshal(data []byte, v any) error {
+	*c.unmarshals++
+	return c.JSONCodec.Unmarshal(data, v)
+}
+
+// TestIterateStopsOnCallbackError guards against Iterate ignoring the
+// error its callback returns and decoding the rest of the collection
+// anyway.
+func TestIterateStopsOnCallbackError(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for i := 0; i < 5; i++ {
+		r := iterateTestRecord{Name: "rec", Seq: i}
+		if err := driver.Write("nums", string(rune('a'+i)), r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	errStop := errors.New("stop here")
+	calls := 0
+	err = driver.Iterate("nums", func(resource string, raw json.RawMessage) error {
+		calls++
+		return errStop
+	})
+
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Iterate returned %v, want errStop", err)
+	}
+	if calls != 1 {
+		t.Fatalf("callback ran %d times, want exactly 1", calls)
+	}
+}
+
+// TestReadAllIntoDecodesTypedSlice guards against ReadAllInto's
+// reflection-based decode losing fields or failing to grow the
+// destination slice to the collection's size.
+func TestReadAllIntoDecodesTypedSlice(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	want := map[string]int{}
+	for i := 0; i < 3; i++ {
+		r := iterateTestRecord{Name: "rec", Seq: i}
+		if err := driver.Write("nums", string(rune('a'+i)), r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		want[r.Name] = want[r.Name] + 1
+	}
+
+	var records []iterateTestRecord
+	if err := driver.ReadAllInto("nums", &records); err != nil {
+		t.Fatalf("ReadAllInto: %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	seqs := map[int]bool{}
+	for _, r := range records {
+		if r.Name != "rec" {
+			t.Fatalf("record decoded with wrong Name: %#v", r)
+		}
+		seqs[r.Seq] = true
+	}
+	for i := 0; i < 3; i++ {
+		if !seqs[i] {
+			t.Fatalf("missing Seq %d in %#v", i, records)
+		}
+	}
+}
+
+// TestReadPageWithoutSortByDecodesAtMostOffsetPlusLimit guards against
+// the regression fixed in 5c58fbb, where ReadPage decoded the whole
+// collection before paging even though no sortBy was requested.
+func TestReadPageWithoutSortByDecodesAtMostOffsetPlusLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	unmarshals := 0
+	driver, err := New(dir, &Options{Codec: countingCodec{unmarshals: &unmarshals}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for i := 0; i < 10; i++ {
+		r := iterateTestRecord{Name: "rec", Seq: i}
+		if err := driver.Write("nums", string(rune('a'+i)), r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	unmarshals = 0
+	page, err := driver.ReadPage("nums", 2, 3, "")
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+
+	if len(page) != 3 {
+		t.Fatalf("got %d records, want 3", len(page))
+	}
+	if unmarshals > 2+3 {
+		t.Fatalf("ReadPage decoded %d records, want at most offset+limit=5", unmarshals)
+	}
+}
+
+// TestReadPageBoundaries guards against off-by-one errors at the edges
+// of a page, both with and without sortBy.
+func TestReadPageBoundaries(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for i := 0; i < 5; i++ {
+		r := iterateTestRecord{Name: "rec", Seq: i}
+		if err := driver.Write("nums", string(rune('a'+i)), r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	// Unsorted: offset at the last record returns a single-element page.
+	page, err := driver.ReadPage("nums", 4, 10, "")
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if len(page) != 1 {
+		t.Fatalf("offset=4 limit=10 got %d records, want 1", len(page))
+	}
+
+	// Unsorted: offset past the end returns an empty page, not an error.
+	page, err = driver.ReadPage("nums", 5, 10, "")
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if len(page) != 0 {
+		t.Fatalf("offset=5 limit=10 got %d records, want 0", len(page))
+	}
+
+	// Sorted by seq: boundary offset/limit returns the expected tail.
+	page, err = driver.ReadPage("nums", 3, 10, "seq")
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if len(page) != 2 {
+		t.Fatalf("sortBy=seq offset=3 limit=10 got %d records, want 2", len(page))
+	}
+	var first iterateTestRecord
+	if err := json.Unmarshal(page[0], &first); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if first.Seq != 3 {
+		t.Fatalf("sortBy=seq offset=3 first record has Seq %d, want 3", first.Seq)
+	}
+}
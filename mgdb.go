@@ -1,15 +1,17 @@
 package mgdb
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/jcelliott/lumber"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
+const defaultGCInterval = time.Minute
+
 const Version = "1.0.0"
 
 type (
@@ -23,14 +25,28 @@ type (
 	}
 
 	Driver struct {
-		mutex     sync.Mutex
-		mutexes   map[string]*sync.Mutex
-		directory string
-		log       Logger
+		shards          [lockShardCount]*lockShard
+		directory       string
+		codec           Codec
+		log             Logger
+		indexMutex      sync.Mutex
+		indexedPaths    map[string][]string
+		indexFileLocks  map[string]*sync.RWMutex
+		collectionMutex sync.Mutex
+		collectionLocks map[string]*sync.RWMutex
+		gcInterval      time.Duration
+		stopJanitor     chan struct{}
+		janitorDone     chan struct{}
+		closeOnce       sync.Once
 	}
 
 	Options struct {
 		Logger
+		Codec Codec
+		// GCInterval controls how often the background janitor scans for
+		// resources written with WriteWithTTL that have expired. It
+		// defaults to one minute.
+		GCInterval time.Duration
 	}
 )
 
@@ -47,19 +63,51 @@ func New(directory string, options *Options) (*Driver, error) {
 		opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
 	}
 
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec{}
+	}
+
+	if opts.GCInterval <= 0 {
+		opts.GCInterval = defaultGCInterval
+	}
+
 	driver := Driver{
-		directory: directory,
-		mutexes:   make(map[string]*sync.Mutex),
-		log:       opts.Logger,
+		directory:   directory,
+		codec:       opts.Codec,
+		log:         opts.Logger,
+		gcInterval:  opts.GCInterval,
+		stopJanitor: make(chan struct{}),
+		janitorDone: make(chan struct{}),
+	}
+
+	for i := range driver.shards {
+		driver.shards[i] = &lockShard{locks: make(map[string]*sync.RWMutex)}
 	}
 
 	if _, err := os.Stat(directory); err == nil {
 		opts.Logger.Debug("Using '%s' (database already exists)\n", directory)
+		// discoverIndexes runs first so recoverWAL knows which paths are
+		// indexed and can bring them back in sync with whatever orphaned
+		// transaction it replays.
+		if err := driver.discoverIndexes(); err != nil {
+			return &driver, err
+		}
+		if err := driver.recoverWAL(); err != nil {
+			return &driver, err
+		}
+		go driver.runJanitor()
 		return &driver, nil
 	}
 
 	opts.Logger.Debug("Creating the database at '%s'...\n", directory)
-	return &driver, os.MkdirAll(directory, 0755)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return &driver, err
+	}
+	if err := driver.recoverWAL(); err != nil {
+		return &driver, err
+	}
+	go driver.runJanitor()
+	return &driver, nil
 }
 
 func (d *Driver) Write(collection, resource string, v any) error {
@@ -71,30 +119,49 @@ func (d *Driver) Write(collection, resource string, v any) error {
 		return fmt.Errorf("missing resource - unable to save record (no name)")
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	lock := d.resourceLock(collection, resource)
+	lock.Lock()
+	defer lock.Unlock()
 
 	dir := filepath.Join(d.directory, collection)
-	file := filepath.Join(dir, resource+".json")
+	file := filepath.Join(dir, resource+"."+d.codec.Extension())
 	tmpFile := file + ".tmp"
 
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	bytes, err := json.MarshalIndent(v, "", "\t")
+	bytes, err := d.codec.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	bytes = append(bytes, byte('\n'))
-
 	if err := ioutil.WriteFile(tmpFile, bytes, 0644); err != nil {
 		return err
 	}
 
-	return os.Rename(tmpFile, file)
+	if err := os.Rename(tmpFile, file); err != nil {
+		return err
+	}
+
+	// A plain Write always resets a resource's expiry: otherwise a
+	// WriteWithTTL'd resource that's overwritten later via Write would
+	// still carry its old "<resource>.meta.json" sidecar, so Read would
+	// treat the fresh data as ErrExpired and the janitor would delete it.
+	_ = os.Remove(d.metaFile(collection, resource))
+
+	if paths := d.indexPathsFor(collection); len(paths) > 0 {
+		var doc any
+		if err := d.codec.Unmarshal(bytes, &doc); err == nil {
+			d.updateIndexes(collection, resource, normalizeDoc(doc))
+		}
+	}
+
+	return nil
 }
 
 func (d *Driver) Read(collection, resource string, v any) error {
@@ -106,56 +173,66 @@ func (d *Driver) Read(collection, resource string, v any) error {
 		return fmt.Errorf("missing resource - unable to read record (no name)")
 	}
 
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	lock := d.resourceLock(collection, resource)
+	lock.RLock()
+	defer lock.RUnlock()
+
 	record := filepath.Join(d.directory, collection, resource)
+	ext := "." + d.codec.Extension()
 
-	if _, err := stat(record); err != nil {
+	if _, err := stat(record, ext); err != nil {
 		return err
 	}
 
-	bytes, err := ioutil.ReadFile(record + ".json")
-	if err != nil {
+	if expired, err := d.expired(collection, resource); err != nil {
 		return err
+	} else if expired {
+		return ErrExpired
 	}
 
-	return json.Unmarshal(bytes, &v)
-}
-
-func (d *Driver) ReadAll(collection string) ([]string, error) {
-	if collection == "" {
-		return nil, fmt.Errorf("missing collection - unable to read")
+	bytes, err := ioutil.ReadFile(record + ext)
+	if err != nil {
+		return err
 	}
 
-	dir := filepath.Join(d.directory, collection)
-
-	if _, err := stat(dir); err != nil {
-		return nil, err
-	}
+	return d.codec.Unmarshal(bytes, v)
+}
 
-	files, _ := ioutil.ReadDir(dir)
+func (d *Driver) Delete(collection, resource string) error {
+	file := filepath.Join(collection, resource)
 
-	var records []string
+	// An empty resource deletes the whole collection directory. That
+	// has to exclude every other operation on the collection, not just
+	// whatever happens to hash to the same resourceLock as "", so it
+	// takes collectionLock in write mode instead of a resourceLock.
+	if resource == "" {
+		lock := d.collectionLock(collection)
+		lock.Lock()
+		defer lock.Unlock()
 
-	for _, file := range files {
-		bytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
-		if err != nil {
-			return nil, err
+		dir := filepath.Join(d.directory, file)
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("unable to find file or directory named %v\n", file)
 		}
-
-		records = append(records, string(bytes))
+		return os.RemoveAll(dir)
 	}
-	return records, nil
-}
 
-func (d *Driver) Delete(collection, resource string) error {
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
 
-	file := filepath.Join(collection, resource)
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	lock := d.resourceLock(collection, resource)
+	lock.Lock()
+	defer lock.Unlock()
 
 	dir := filepath.Join(d.directory, file)
+	ext := "." + d.codec.Extension()
 
-	switch fInfo, err := stat(dir); {
+	switch fInfo, err := stat(dir, ext); {
 	case fInfo == nil, err != nil:
 		return fmt.Errorf("unable to find file or directory named %v\n", file)
 
@@ -163,28 +240,19 @@ func (d *Driver) Delete(collection, resource string) error {
 		return os.RemoveAll(dir)
 
 	case fInfo.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+		if err := os.RemoveAll(dir + ext); err != nil {
+			return err
+		}
+		_ = os.Remove(d.metaFile(collection, resource))
+		d.removeFromIndexes(collection, resource)
+		return nil
 	}
 	return nil
 }
 
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
-
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	m, ok := d.mutexes[collection]
-
-	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
-	}
-
-	return m
-}
-
-func stat(path string) (fInfo os.FileInfo, err error) {
+func stat(path, ext string) (fInfo os.FileInfo, err error) {
 	if fInfo, err = os.Stat(path); os.IsNotExist(err) {
-		fInfo, err = os.Stat(path + ".json")
+		fInfo, err = os.Stat(path + ext)
 	}
 	return
 }
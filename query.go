@@ -0,0 +1,634 @@
+package mgdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned by FindOne when no record matches the filter.
+var ErrNotFound = errors.New("mgdb: no record matched the filter")
+
+type op int
+
+const (
+	opEq op = iota
+	opGt
+	opLt
+	opIn
+	opRegex
+)
+
+type predicate struct {
+	path  string
+	op    op
+	value any
+}
+
+// Filter describes which records a Find/FindOne call should return, plus
+// how the results should be ordered and paged. Build one with Eq, Gt, Lt,
+// In, or Regex, chain more predicates with And, and narrow the result set
+// with Sort, Limit, and Skip. All predicates on a Filter must match
+// (logical AND).
+type Filter struct {
+	predicates []predicate
+	sortBy     string
+	limit      int
+	skip       int
+}
+
+// Eq matches records whose value at path equals value.
+func Eq(path string, value any) Filter { return Filter{predicates: []predicate{{path, opEq, value}}} }
+
+// Gt matches records whose value at path is greater than value.
+func Gt(path string, value any) Filter { return Filter{predicates: []predicate{{path, opGt, value}}} }
+
+// Lt matches records whose value at path is less than value.
+func Lt(path string, value any) Filter { return Filter{predicates: []predicate{{path, opLt, value}}} }
+
+// In matches records whose value at path equals one of values.
+func In(path string, values []any) Filter {
+	return Filter{predicates: []predicate{{path, opIn, values}}}
+}
+
+// Regex matches records whose value at path, rendered as text with
+// fmt.Sprint, matches pattern. An invalid pattern matches no record.
+func Regex(path, pattern string) Filter {
+	return Filter{predicates: []predicate{{path, opRegex, pattern}}}
+}
+
+// And returns a Filter requiring both f's and other's predicates to match.
+func (f Filter) And(other Filter) Filter {
+	f.predicates = append(append([]predicate{}, f.predicates...), other.predicates...)
+	return f
+}
+
+// Sort orders results by the value at path, ascending, using compare:
+// numbers are compared numerically and other values as text.
+func (f Filter) Sort(path string) Filter {
+	f.sortBy = path
+	return f
+}
+
+// Limit caps the number of results returned.
+func (f Filter) Limit(n int) Filter {
+	f.limit = n
+	return f
+}
+
+// Skip discards the first n matching results before Limit is applied.
+func (f Filter) Skip(n int) Filter {
+	f.skip = n
+	return f
+}
+
+func (f Filter) matches(doc any) bool {
+	for _, p := range f.predicates {
+		value, ok := getPath(doc, p.path)
+		if !ok {
+			return false
+		}
+
+		switch p.op {
+		case opEq:
+			if !valuesEqual(value, p.value) {
+				return false
+			}
+
+		case opGt:
+			if compare(value, p.value) <= 0 {
+				return false
+			}
+
+		case opLt:
+			if compare(value, p.value) >= 0 {
+				return false
+			}
+
+		case opIn:
+			values, _ := p.value.([]any)
+			found := false
+			for _, v := range values {
+				if valuesEqual(value, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+
+		case opRegex:
+			pattern, _ := p.value.(string)
+			re, err := regexp.Compile(pattern)
+			if err != nil || !re.MatchString(fmt.Sprint(value)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// getPath walks doc along a dot-separated JSON path (e.g. "address.city")
+// and returns the value found there, if any.
+func getPath(doc any, path string) (any, bool) {
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// compare orders two decoded JSON values numerically when both are
+// numbers, and lexically otherwise.
+func compare(a, b any) int {
+	af, aIsNum := toFloat(a)
+	bf, bIsNum := toFloat(b)
+
+	if aIsNum && bIsNum {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+
+	// BSON and MessagePack decode integers as int32, int8, uint64, etc.
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+// valuesEqual reports whether two decoded values are equal for opEq/opIn
+// purposes, comparing numerically when both sides are numbers and
+// lexically otherwise. A plain fmt.Sprint comparison would fail for a
+// query like Eq("age", 1000000): JSON numbers decode to float64, and
+// fmt.Sprint(float64(1000000)) renders as "1e+06", which never matches
+// the int 1000000 passed to Eq.
+func valuesEqual(a, b any) bool {
+	af, aIsNum := toFloat(a)
+	bf, bIsNum := toFloat(b)
+	if aIsNum && bIsNum {
+		return af == bf
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// indexKey canonicalizes a value into the string key used for secondary
+// index entries and Eq lookups, so the same value produces the same key
+// whether it arrives as a query argument (e.g. the int 1000000 passed to
+// Eq) or a value decoded off disk (the float64 1000000). fmt.Sprint alone
+// renders large floats in scientific notation ("1e+06"), which would
+// silently desync index keys from query lookups.
+func indexKey(value any) string {
+	if f, ok := toFloat(value); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Find returns every record in collection matching filter, decoded to
+// generic JSON regardless of the Driver's Codec, honoring filter's Sort,
+// Skip, and Limit. An Eq predicate on an indexed path (see CreateIndex) is
+// used to narrow the scan instead of decoding the whole collection.
+func (d *Driver) Find(collection string, filter Filter) ([]json.RawMessage, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("missing collection - unable to query")
+	}
+
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	ext := "." + d.codec.Extension()
+	dir := filepath.Join(d.directory, collection)
+
+	if _, err := stat(dir, ext); err != nil {
+		return nil, err
+	}
+
+	resources, err := d.candidateResources(collection, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	type hit struct {
+		resource string
+		doc      any
+	}
+
+	var hits []hit
+
+	for _, resource := range resources {
+		if expired, err := d.expired(collection, resource); err == nil && expired {
+			continue
+		}
+
+		lock := d.resourceLock(collection, resource)
+		lock.RLock()
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, resource+ext))
+		lock.RUnlock()
+		if err != nil {
+			continue
+		}
+
+		var doc any
+		if err := d.codec.Unmarshal(bytes, &doc); err != nil {
+			continue
+		}
+		doc = normalizeDoc(doc)
+
+		if filter.matches(doc) {
+			hits = append(hits, hit{resource, doc})
+		}
+	}
+
+	if filter.sortBy != "" {
+		sort.SliceStable(hits, func(i, j int) bool {
+			vi, _ := getPath(hits[i].doc, filter.sortBy)
+			vj, _ := getPath(hits[j].doc, filter.sortBy)
+			return compare(vi, vj) < 0
+		})
+	}
+
+	if filter.skip > 0 {
+		if filter.skip >= len(hits) {
+			hits = nil
+		} else {
+			hits = hits[filter.skip:]
+		}
+	}
+
+	if filter.limit > 0 && filter.limit < len(hits) {
+		hits = hits[:filter.limit]
+	}
+
+	results := make([]json.RawMessage, 0, len(hits))
+	for _, h := range hits {
+		raw, err := json.Marshal(h.doc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, raw)
+	}
+	return results, nil
+}
+
+// FindOne returns the first record in collection matching filter, or
+// ErrNotFound if none match.
+func (d *Driver) FindOne(collection string, filter Filter) (json.RawMessage, error) {
+	results, err := d.Find(collection, filter.Limit(1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return results[0], nil
+}
+
+// candidateResources returns the resource names Find should decode and
+// test against filter: the indexed set for an Eq predicate on an indexed
+// path, or every resource in the collection otherwise.
+func (d *Driver) candidateResources(collection string, filter Filter) ([]string, error) {
+	for _, p := range filter.predicates {
+		if p.op != opEq || !d.isIndexed(collection, p.path) {
+			continue
+		}
+
+		lock := d.indexFileLock(collection)
+		lock.RLock()
+		index, err := d.loadIndex(collection, p.path)
+		lock.RUnlock()
+		if err != nil {
+			continue
+		}
+
+		return index[indexKey(p.value)], nil
+	}
+
+	ext := "." + d.codec.Extension()
+	dir := filepath.Join(d.directory, collection)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []string
+	for _, file := range files {
+		if file.IsDir() || !isResourceFile(file.Name(), ext) {
+			continue
+		}
+		resources = append(resources, strings.TrimSuffix(file.Name(), ext))
+	}
+	return resources, nil
+}
+
+// CreateIndex builds and registers a secondary index over jsonPath in
+// collection, so that future Eq lookups against it (via Find/FindOne) scan
+// only matching resources. The index is kept up to date by Write and
+// Delete from this point on.
+//
+// The whole backfill - registering jsonPath, scanning the collection, and
+// writing the resulting index - runs under collection's index file lock,
+// the same lock updateIndexes/removeFromIndexes take for every Write and
+// Delete. Without that, a Write landing between the directory scan and
+// the final save would be recorded correctly by updateIndexes but then
+// silently discarded when CreateIndex overwrote the file with its stale
+// snapshot.
+//
+// The backfill deliberately reads each resource file without taking its
+// resourceLock: Write and Delete take that lock first and only acquire
+// the index file lock afterwards (to call updateIndexes/removeFromIndexes),
+// so doing the same here - indexFileLock, then resourceLock - would
+// invert that order and deadlock against a concurrent Write/Delete. Since
+// a file's rename/removal is already atomic, an unlocked read only ever
+// sees a fully-old or fully-new file; any write racing with the scan is
+// still caught correctly because its updateIndexes/removeFromIndexes
+// call blocks on indexFileLock until this backfill's saveIndex runs.
+func (d *Driver) CreateIndex(collection, jsonPath string) error {
+	if collection == "" {
+		return fmt.Errorf("missing collection - unable to index")
+	}
+
+	if jsonPath == "" {
+		return fmt.Errorf("missing json path - unable to index")
+	}
+
+	clock := d.collectionLock(collection)
+	clock.RLock()
+	defer clock.RUnlock()
+
+	lock := d.indexFileLock(collection)
+	lock.Lock()
+	defer lock.Unlock()
+
+	d.indexMutex.Lock()
+	if d.indexedPaths == nil {
+		d.indexedPaths = make(map[string][]string)
+	}
+	if !contains(d.indexedPaths[collection], jsonPath) {
+		d.indexedPaths[collection] = append(d.indexedPaths[collection], jsonPath)
+	}
+	d.indexMutex.Unlock()
+
+	index := make(map[string][]string)
+
+	ext := "." + d.codec.Extension()
+	dir := filepath.Join(d.directory, collection)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d.saveIndex(collection, jsonPath, index)
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isResourceFile(file.Name(), ext) {
+			continue
+		}
+
+		resource := strings.TrimSuffix(file.Name(), ext)
+
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+
+		var doc any
+		if err := d.codec.Unmarshal(bytes, &doc); err != nil {
+			continue
+		}
+		doc = normalizeDoc(doc)
+
+		if value, ok := getPath(doc, jsonPath); ok {
+			key := indexKey(value)
+			index[key] = append(index[key], resource)
+		}
+	}
+
+	return d.saveIndex(collection, jsonPath, index)
+}
+
+func (d *Driver) isIndexed(collection, jsonPath string) bool {
+	d.indexMutex.Lock()
+	defer d.indexMutex.Unlock()
+	return contains(d.indexedPaths[collection], jsonPath)
+}
+
+// discoverIndexes populates indexedPaths from every "<path>.idx" file
+// already on disk under each collection's ".index" directory, so a
+// Driver reopened over an existing database keeps serving indexed Eq
+// lookups - and keeps those indexes current on Write/Delete - without
+// the caller re-running CreateIndex. It is called once from New().
+func (d *Driver) discoverIndexes() error {
+	collections, err := ioutil.ReadDir(d.directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, c := range collections {
+		if !c.IsDir() || c.Name() == ".wal" {
+			continue
+		}
+
+		collection := c.Name()
+		indexDir := filepath.Join(d.directory, collection, ".index")
+
+		files, err := ioutil.ReadDir(indexDir)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != ".idx" {
+				continue
+			}
+
+			jsonPath := strings.TrimSuffix(f.Name(), ".idx")
+
+			d.indexMutex.Lock()
+			if d.indexedPaths == nil {
+				d.indexedPaths = make(map[string][]string)
+			}
+			if !contains(d.indexedPaths[collection], jsonPath) {
+				d.indexedPaths[collection] = append(d.indexedPaths[collection], jsonPath)
+			}
+			d.indexMutex.Unlock()
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) indexPathsFor(collection string) []string {
+	d.indexMutex.Lock()
+	defer d.indexMutex.Unlock()
+	return append([]string{}, d.indexedPaths[collection]...)
+}
+
+// indexFileLock returns the RWMutex serializing reads and read-modify-
+// write updates of collection's index files, creating it on first use.
+func (d *Driver) indexFileLock(collection string) *sync.RWMutex {
+	d.indexMutex.Lock()
+	defer d.indexMutex.Unlock()
+
+	if d.indexFileLocks == nil {
+		d.indexFileLocks = make(map[string]*sync.RWMutex)
+	}
+
+	lock, ok := d.indexFileLocks[collection]
+	if !ok {
+		lock = &sync.RWMutex{}
+		d.indexFileLocks[collection] = lock
+	}
+	return lock
+}
+
+func (d *Driver) indexFile(collection, jsonPath string) string {
+	return filepath.Join(d.directory, collection, ".index", jsonPath+".idx")
+}
+
+func (d *Driver) loadIndex(collection, jsonPath string) (map[string][]string, error) {
+	bytes, err := ioutil.ReadFile(d.indexFile(collection, jsonPath))
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string][]string)
+	if err := json.Unmarshal(bytes, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (d *Driver) saveIndex(collection, jsonPath string, index map[string][]string) error {
+	file := d.indexFile(collection, jsonPath)
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, bytes, 0644)
+}
+
+// updateIndexes refreshes every index registered on collection so that
+// resource is filed under doc's current values.
+func (d *Driver) updateIndexes(collection, resource string, doc any) {
+	lock := d.indexFileLock(collection)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, jsonPath := range d.indexPathsFor(collection) {
+		index, err := d.loadIndex(collection, jsonPath)
+		if err != nil {
+			index = make(map[string][]string)
+		}
+
+		for key, resources := range index {
+			index[key] = removeString(resources, resource)
+		}
+
+		if value, ok := getPath(doc, jsonPath); ok {
+			key := indexKey(value)
+			index[key] = append(index[key], resource)
+		}
+
+		_ = d.saveIndex(collection, jsonPath, index)
+	}
+}
+
+// removeFromIndexes drops resource from every index registered on
+// collection.
+func (d *Driver) removeFromIndexes(collection, resource string) {
+	lock := d.indexFileLock(collection)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, jsonPath := range d.indexPathsFor(collection) {
+		index, err := d.loadIndex(collection, jsonPath)
+		if err != nil {
+			continue
+		}
+
+		for key, resources := range index {
+			index[key] = removeString(resources, resource)
+		}
+
+		_ = d.saveIndex(collection, jsonPath, index)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
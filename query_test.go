@@ -0,0 +1,171 @@
+package mgdb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type queryTestRecord struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+// TestFindUsesIndexedEqLookup guards against the index-key / query-value
+// mismatch that numbers are prone to: an indexed Eq lookup for a value
+// like 1000000 must find the record, even though the index stores
+// decoded float64s and the query passes a Go int.
+func TestFindUsesIndexedEqLookup(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for _, r := range []queryTestRecord{
+		{Name: "alice", Age: 30},
+		{Name: "bob", Age: 1000000},
+		{Name: "carol", Age: 30},
+	} {
+		if err := driver.Write("users", r.Name, r); err != nil {
+			t.Fatalf("Write %s: %v", r.Name, err)
+		}
+	}
+
+	if err := driver.CreateIndex("users", "age"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	results, err := driver.Find("users", Eq("age", 1000000))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result for Eq(age, 1000000), got %d: %s", len(results), results)
+	}
+
+	var got queryTestRecord
+	if err := json.Unmarshal(results[0], &got); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	if got.Name != "bob" {
+		t.Fatalf("expected bob, got %q", got.Name)
+	}
+}
+
+// TestFindSortSkipLimit covers paging a Filter: sorting by a field,
+// skipping the first match, and capping the rest at one result.
+func TestFindSortSkipLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for _, r := range []queryTestRecord{
+		{Name: "dave", Age: 40},
+		{Name: "erin", Age: 20},
+		{Name: "frank", Age: 30},
+	} {
+		if err := driver.Write("users", r.Name, r); err != nil {
+			t.Fatalf("Write %s: %v", r.Name, err)
+		}
+	}
+
+	page, err := driver.Find("users", Filter{}.Sort("age").Skip(1).Limit(1))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(page) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(page))
+	}
+
+	var got queryTestRecord
+	if err := json.Unmarshal(page[0], &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.Name != "frank" {
+		t.Fatalf("expected frank (second-youngest), got %q", got.Name)
+	}
+}
+
+// TestIndexSurvivesRestart guards against CreateIndex's registration
+// being in-memory only: after reopening the Driver over the same
+// directory, a Write must still be reflected in the on-disk index
+// without the caller calling CreateIndex again.
+func TestIndexSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := first.Write("users", "alice", queryTestRecord{Name: "alice", Age: 30}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := first.CreateIndex("users", "age"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	first.Close()
+
+	reopened, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New (reopen): %v", err)
+	}
+	defer reopened.Close()
+
+	if err := reopened.Write("users", "bob", queryTestRecord{Name: "bob", Age: 40}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	index, err := reopened.loadIndex("users", "age")
+	if err != nil {
+		t.Fatalf("loadIndex: %v", err)
+	}
+	if _, ok := index[indexKey(40)]; !ok {
+		t.Fatalf("expected reopened Driver to keep maintaining the 'age' index, got %#v", index)
+	}
+}
+
+// TestCreateIndexSurvivesConcurrentWrite guards against CreateIndex's
+// backfill overwriting the index with a stale snapshot: a Write landing
+// while CreateIndex is scanning the collection must still show up in the
+// index afterwards, not get silently dropped until its next write.
+func TestCreateIndexSurvivesConcurrentWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	for i := 0; i < 20; i++ {
+		r := queryTestRecord{Name: "seed", Age: i}
+		if err := driver.Write("users", string(rune('a'+i)), r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = driver.Write("users", "latecomer", queryTestRecord{Name: "latecomer", Age: 99})
+	}()
+
+	if err := driver.CreateIndex("users", "age"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	<-done
+
+	index, err := driver.loadIndex("users", "age")
+	if err != nil {
+		t.Fatalf("loadIndex: %v", err)
+	}
+	if !contains(index[indexKey(99)], "latecomer") {
+		t.Fatalf("expected concurrent write of 'latecomer' to survive CreateIndex's backfill, got %#v", index)
+	}
+}
@@ -0,0 +1,150 @@
+package mgdb
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrExpired is returned by Read when the requested resource's TTL (set
+// via WriteWithTTL) has passed, even if the janitor hasn't collected it
+// yet.
+var ErrExpired = errors.New("mgdb: resource has expired")
+
+const metaSuffix = ".meta.json"
+
+type resourceMeta struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// isResourceFile reports whether name is a genuine resource file for a
+// collection using the given codec extension, as opposed to a
+// ".meta.json" TTL sidecar. A sidecar like "alice.meta.json" still ends
+// in the default JSONCodec's ".json" extension, so every directory
+// scanner must exclude metaSuffix explicitly or it will treat the
+// sidecar as a resource named "alice.meta".
+func isResourceFile(name, ext string) bool {
+	return filepath.Ext(name) == ext && !strings.HasSuffix(name, metaSuffix)
+}
+
+// WriteWithTTL writes v to collection/resource like Write, and records
+// that it should be treated as expired (and eventually collected by the
+// janitor) once ttl elapses.
+func (d *Driver) WriteWithTTL(collection, resource string, v any, ttl time.Duration) error {
+	if err := d.Write(collection, resource, v); err != nil {
+		return err
+	}
+	return d.writeMeta(collection, resource, time.Now().Add(ttl))
+}
+
+func (d *Driver) metaFile(collection, resource string) string {
+	return filepath.Join(d.directory, collection, resource+metaSuffix)
+}
+
+func (d *Driver) writeMeta(collection, resource string, expiresAt time.Time) error {
+	bytes, err := json.MarshalIndent(resourceMeta{ExpiresAt: expiresAt}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	file := d.metaFile(collection, resource)
+	tmp := file + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// readMeta returns the resource's TTL metadata, or nil if it has none.
+func (d *Driver) readMeta(collection, resource string) (*resourceMeta, error) {
+	bytes, err := ioutil.ReadFile(d.metaFile(collection, resource))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var meta resourceMeta
+	if err := json.Unmarshal(bytes, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (d *Driver) expired(collection, resource string) (bool, error) {
+	meta, err := d.readMeta(collection, resource)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	return time.Now().After(meta.ExpiresAt), nil
+}
+
+// runJanitor periodically scans every collection for expired resources
+// and deletes them, until Close stops it. It is started once from New.
+func (d *Driver) runJanitor() {
+	defer close(d.janitorDone)
+
+	ticker := time.NewTicker(d.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stopJanitor:
+			return
+		case <-ticker.C:
+			d.collectExpired()
+		}
+	}
+}
+
+func (d *Driver) collectExpired() {
+	collections, err := ioutil.ReadDir(d.directory)
+	if err != nil {
+		return
+	}
+
+	for _, c := range collections {
+		if !c.IsDir() || c.Name() == ".wal" {
+			continue
+		}
+
+		collection := c.Name()
+		dir := filepath.Join(d.directory, collection)
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), metaSuffix) {
+				continue
+			}
+
+			resource := strings.TrimSuffix(f.Name(), metaSuffix)
+
+			if expired, err := d.expired(collection, resource); err != nil || !expired {
+				continue
+			}
+
+			_ = d.Delete(collection, resource)
+		}
+	}
+}
+
+// Close stops the Driver's background janitor goroutine. A Driver that
+// is never closed leaks that goroutine for the life of the process.
+func (d *Driver) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.stopJanitor)
+		<-d.janitorDone
+	})
+	return nil
+}
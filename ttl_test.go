@@ -0,0 +1,42 @@
+package mgdb
+
+import (
+	"testing"
+	"time"
+)
+
+type ttlTestRecord struct {
+	Name string `json:"name"`
+}
+
+// TestReadAllIntoExcludesExpiredAndMetaSidecars guards against the
+// "<resource>.meta.json" sidecar being mistaken for a resource under the
+// default JSONCodec, where its own ".json" extension collides with the
+// codec's.
+func TestReadAllIntoExcludesExpiredAndMetaSidecars(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	if err := driver.Write("users", "alice", ttlTestRecord{Name: "alice"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := driver.WriteWithTTL("users", "bob", ttlTestRecord{Name: "bob"}, time.Millisecond); err != nil {
+		t.Fatalf("WriteWithTTL: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	var records []ttlTestRecord
+	if err := driver.ReadAllInto("users", &records); err != nil {
+		t.Fatalf("ReadAllInto: %v", err)
+	}
+
+	if len(records) != 1 || records[0].Name != "alice" {
+		t.Fatalf("expected only alice to survive, got %#v", records)
+	}
+}
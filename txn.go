@@ -0,0 +1,384 @@
+package mgdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type txnOpKind int
+
+const (
+	txnWrite txnOpKind = iota
+	txnDelete
+)
+
+type txnOp struct {
+	kind       txnOpKind
+	collection string
+	resource   string
+	value      any
+}
+
+// Txn batches Write, Delete, and Read calls against one or more
+// collections so that Commit applies them all atomically: either every
+// buffered operation lands on disk, or none do. A Txn is not safe for
+// concurrent use and must not be reused after Commit.
+type Txn struct {
+	driver *Driver
+	id     string
+	ops    []txnOp
+}
+
+// Begin starts a new transaction against the Driver. Buffer operations on
+// the returned Txn with Write and Delete, then call Commit to apply them.
+func (d *Driver) Begin() *Txn {
+	return &Txn{driver: d, id: newTxnID()}
+}
+
+// Write buffers a write of v to collection/resource, to be applied when
+// Commit is called.
+func (t *Txn) Write(collection, resource string, v any) {
+	t.ops = append(t.ops, txnOp{kind: txnWrite, collection: collection, resource: resource, value: v})
+}
+
+// Delete buffers removal of collection/resource, to be applied when
+// Commit is called.
+func (t *Txn) Delete(collection, resource string) {
+	t.ops = append(t.ops, txnOp{kind: txnDelete, collection: collection, resource: resource})
+}
+
+// Read returns the most recently buffered value written to
+// collection/resource in this transaction, falling back to the Driver's
+// committed data if nothing has been buffered for it yet.
+func (t *Txn) Read(collection, resource string, v any) error {
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		op := t.ops[i]
+		if op.collection != collection || op.resource != resource {
+			continue
+		}
+
+		if op.kind == txnDelete {
+			return fmt.Errorf("mgdb: %s/%s was deleted earlier in this transaction", collection, resource)
+		}
+
+		bytes, err := t.driver.codec.Marshal(op.value)
+		if err != nil {
+			return err
+		}
+		return t.driver.codec.Unmarshal(bytes, v)
+	}
+
+	return t.driver.Read(collection, resource, v)
+}
+
+// walEntry is the on-disk record of a transaction's planned effects,
+// written before any rename or delete is performed so a crash mid-commit
+// can be completed on the next New().
+type walEntry struct {
+	ID      string      `json:"id"`
+	Renames []walRename `json:"renames"`
+	Deletes []string    `json:"deletes"`
+}
+
+type walRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Commit applies every buffered operation atomically: it stages each
+// write to a ".tmp" file, records the planned renames and deletes in a
+// write-ahead log, fsyncs that log, then performs the renames and
+// deletes before removing the log entry.
+func (t *Txn) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+
+	for _, collection := range t.collections() {
+		lock := t.driver.collectionLock(collection)
+		lock.RLock()
+		defer lock.RUnlock()
+	}
+
+	for _, key := range t.resourceKeys() {
+		lock := t.driver.resourceLock(key.collection, key.resource)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	entry := walEntry{ID: t.id}
+	type staged struct {
+		collection string
+		resource   string
+		doc        any
+	}
+	var writes []staged
+
+	for _, op := range t.ops {
+		dir := filepath.Join(t.driver.directory, op.collection)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		ext := "." + t.driver.codec.Extension()
+		final := filepath.Join(dir, op.resource+ext)
+
+		switch op.kind {
+		case txnWrite:
+			bytes, err := t.driver.codec.Marshal(op.value)
+			if err != nil {
+				return err
+			}
+
+			tmp := final + ".tmp"
+			if err := ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+				return err
+			}
+
+			entry.Renames = append(entry.Renames, walRename{From: tmp, To: final})
+			entry.Deletes = append(entry.Deletes, t.driver.metaFile(op.collection, op.resource))
+
+			var doc any
+			if err := t.driver.codec.Unmarshal(bytes, &doc); err == nil {
+				writes = append(writes, staged{op.collection, op.resource, normalizeDoc(doc)})
+			}
+
+		case txnDelete:
+			entry.Deletes = append(entry.Deletes, final, t.driver.metaFile(op.collection, op.resource))
+		}
+	}
+
+	walDir := filepath.Join(t.driver.directory, ".wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		return err
+	}
+
+	walFile := filepath.Join(walDir, t.id+".log")
+	if err := writeWAL(walFile, entry); err != nil {
+		return err
+	}
+
+	if err := applyWAL(entry); err != nil {
+		return err
+	}
+
+	if err := os.Remove(walFile); err != nil {
+		return err
+	}
+
+	for _, w := range writes {
+		t.driver.updateIndexes(w.collection, w.resource, w.doc)
+	}
+	for _, op := range t.ops {
+		if op.kind == txnDelete {
+			t.driver.removeFromIndexes(op.collection, op.resource)
+		}
+	}
+
+	return nil
+}
+
+// resourceKey identifies a (collection, resource) pair a Txn touches.
+type resourceKey struct {
+	collection string
+	resource   string
+}
+
+// resourceKeys returns the distinct (collection, resource) pairs touched
+// by the transaction's buffered operations, sorted so that Commit always
+// acquires locks in the same order regardless of op order.
+func (t *Txn) resourceKeys() []resourceKey {
+	seen := make(map[string]bool)
+	var keys []resourceKey
+	for _, op := range t.ops {
+		k := op.collection + "/" + op.resource
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		keys = append(keys, resourceKey{op.collection, op.resource})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].collection+"/"+keys[i].resource < keys[j].collection+"/"+keys[j].resource
+	})
+	return keys
+}
+
+// collections returns the distinct collection names touched by the
+// transaction's buffered operations, sorted so that Commit always
+// acquires collectionLocks in the same order regardless of op order.
+// Commit takes each in read mode, alongside the resourceLocks from
+// resourceKeys, so it keeps running concurrently with other Txns and
+// Writes/Reads/Deletes while still being excluded by a whole-collection
+// Delete.
+func (t *Txn) collections() []string {
+	seen := make(map[string]bool)
+	var collections []string
+	for _, op := range t.ops {
+		if seen[op.collection] {
+			continue
+		}
+		seen[op.collection] = true
+		collections = append(collections, op.collection)
+	}
+
+	sort.Strings(collections)
+	return collections
+}
+
+func newTxnID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return hex.EncodeToString([]byte(fmt.Sprintf("%p", buf)))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func writeWAL(path string, entry walEntry) error {
+	bytes, err := json.MarshalIndent(entry, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(bytes); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// applyWAL performs the renames and deletes recorded in entry. It is safe
+// to call more than once for the same entry: a rename whose source is
+// already gone (because a prior attempt completed it) is treated as done,
+// and deletes are idempotent by construction.
+func applyWAL(entry walEntry) error {
+	for _, r := range entry.Renames {
+		if _, err := os.Stat(r.From); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(r.From, r.To); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range entry.Deletes {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverWAL finishes any transactions whose write-ahead log survived a
+// crash between the log being fsynced and its removal at the end of
+// Commit. It is called once from New().
+func (d *Driver) recoverWAL() error {
+	walDir := filepath.Join(d.directory, ".wal")
+
+	files, err := ioutil.ReadDir(walDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".log" {
+			continue
+		}
+
+		path := filepath.Join(walDir, file.Name())
+
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var entry walEntry
+		if err := json.Unmarshal(bytes, &entry); err != nil {
+			return err
+		}
+
+		if err := applyWAL(entry); err != nil {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		d.reindexWALEntry(entry)
+	}
+
+	return nil
+}
+
+// reindexWALEntry brings secondary indexes back in sync with the renames
+// and deletes recoverWAL just replayed. Commit updates indexes itself
+// right after applying its WAL entry; a transaction that only gets
+// finished here, on the next New() after a crash, would otherwise leave
+// every index it touched stale until some unrelated later Write/Delete
+// of the same resource happened to refresh it.
+func (d *Driver) reindexWALEntry(entry walEntry) {
+	ext := "." + d.codec.Extension()
+
+	for _, r := range entry.Renames {
+		collection, resource, ok := splitResourceFile(r.To, ext)
+		if !ok {
+			continue
+		}
+
+		bytes, err := ioutil.ReadFile(r.To)
+		if err != nil {
+			continue
+		}
+
+		var doc any
+		if err := d.codec.Unmarshal(bytes, &doc); err != nil {
+			continue
+		}
+
+		d.updateIndexes(collection, resource, normalizeDoc(doc))
+	}
+
+	for _, path := range entry.Deletes {
+		if strings.HasSuffix(path, metaSuffix) {
+			continue
+		}
+
+		collection, resource, ok := splitResourceFile(path, ext)
+		if !ok {
+			continue
+		}
+
+		d.removeFromIndexes(collection, resource)
+	}
+}
+
+// splitResourceFile recovers the (collection, resource) pair a resource
+// file path belongs to, given the codec's file extension, so WAL
+// recovery can reindex a path without re-deriving it from a Txn.
+func splitResourceFile(path, ext string) (collection, resource string, ok bool) {
+	if filepath.Ext(path) != ext {
+		return "", "", false
+	}
+	resource = strings.TrimSuffix(filepath.Base(path), ext)
+	collection = filepath.Base(filepath.Dir(path))
+	return collection, resource, true
+}
@@ -0,0 +1,188 @@
+package mgdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRecoverWALFinishesOrphanedCommit simulates a crash between Commit
+// fsyncing its WAL entry and removing it: the staged ".tmp" file and the
+// WAL log are left on disk, and a fresh New() over the same directory
+// must finish the rename and delete the log before the collection can be
+// trusted again.
+func TestRecoverWALFinishesOrphanedCommit(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	first.Close()
+
+	collection := filepath.Join(dir, "users")
+	if err := os.MkdirAll(collection, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	final := filepath.Join(collection, "alice.json")
+	tmp := final + ".tmp"
+	if err := os.WriteFile(tmp, []byte(`{"name":"alice"}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	walDir := filepath.Join(dir, ".wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	entry := walEntry{ID: "orphan", Renames: []walRename{{From: tmp, To: final}}}
+	if err := writeWAL(filepath.Join(walDir, entry.ID+".log"), entry); err != nil {
+		t.Fatalf("writeWAL: %v", err)
+	}
+
+	recovered, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New (recovery): %v", err)
+	}
+	defer recovered.Close()
+
+	if _, err := os.Stat(final); err != nil {
+		t.Fatalf("expected %s to exist after recovery: %v", final, err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("expected tmp file to be gone after recovery, stat err = %v", err)
+	}
+	if entries, _ := os.ReadDir(walDir); len(entries) != 0 {
+		t.Fatalf("expected WAL log to be removed after recovery, found %d entries", len(entries))
+	}
+}
+
+// TestRecoverWALReindexesOrphanedCommit simulates a crash between Commit
+// fsyncing its WAL entry and removing it, for a transaction that changed
+// an indexed field: recovery must bring the "age" index back in sync
+// with the recovered data, not just the file on disk, or Find would miss
+// a resource until some unrelated later Write/Delete touched it again.
+func TestRecoverWALReindexesOrphanedCommit(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := first.Write("users", "alice", queryTestRecord{Name: "alice", Age: 30}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := first.CreateIndex("users", "age"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	first.Close()
+
+	collection := filepath.Join(dir, "users")
+	final := filepath.Join(collection, "alice.json")
+	tmp := final + ".tmp"
+	if err := os.WriteFile(tmp, []byte(`{"name":"alice","age":31}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	walDir := filepath.Join(dir, ".wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	entry := walEntry{ID: "orphan-reindex", Renames: []walRename{{From: tmp, To: final}}}
+	if err := writeWAL(filepath.Join(walDir, entry.ID+".log"), entry); err != nil {
+		t.Fatalf("writeWAL: %v", err)
+	}
+
+	recovered, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New (recovery): %v", err)
+	}
+	defer recovered.Close()
+
+	results, err := recovered.Find("users", Eq("age", 31))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Find(age=31) returned %d results after recovery, want 1 (index was not refreshed)", len(results))
+	}
+
+	stale, err := recovered.Find("users", Eq("age", 30))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("Find(age=30) returned %d results after recovery, want 0 (stale index entry was not dropped)", len(stale))
+	}
+}
+
+// TestCommitDeleteRemovesTTLSidecar guards against a transactional
+// Delete leaving a resource's "<resource>.meta.json" TTL sidecar behind,
+// which would make a later Write of the same resource read back as
+// ErrExpired.
+func TestCommitDeleteRemovesTTLSidecar(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	if err := driver.WriteWithTTL("users", "alice", map[string]string{"name": "alice"}, time.Hour); err != nil {
+		t.Fatalf("WriteWithTTL: %v", err)
+	}
+
+	txn := driver.Begin()
+	txn.Delete("users", "alice")
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if err := driver.Write("users", "alice", map[string]string{"name": "alice again"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var out map[string]string
+	if err := driver.Read("users", "alice", &out); err != nil {
+		t.Fatalf("Read: %v (orphaned TTL sidecar was not cleaned up by Commit)", err)
+	}
+}
+
+// TestCommitWriteRemovesTTLSidecar guards against a transactional Write
+// leaving a resource's "<resource>.meta.json" TTL sidecar behind: once an
+// expired TTL resource is overwritten inside a transaction, the fresh
+// data it commits must read back as plain data, not ErrExpired, and must
+// not be deleted out from under it on the janitor's next sweep.
+func TestCommitWriteRemovesTTLSidecar(t *testing.T) {
+	dir := t.TempDir()
+
+	driver, err := New(dir, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer driver.Close()
+
+	if err := driver.WriteWithTTL("users", "alice", map[string]string{"name": "alice"}, time.Millisecond); err != nil {
+		t.Fatalf("WriteWithTTL: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	txn := driver.Begin()
+	txn.Write("users", "alice", map[string]string{"name": "alice again"})
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var out map[string]string
+	if err := driver.Read("users", "alice", &out); err != nil {
+		t.Fatalf("Read: %v (orphaned TTL sidecar was not cleaned up by Commit)", err)
+	}
+	if out["name"] != "alice again" {
+		t.Fatalf("Read returned %v, want fresh committed value", out)
+	}
+}